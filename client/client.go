@@ -0,0 +1,132 @@
+// Package client talks to a pcopy server over HTTPS: fetching its public info, verifying credentials
+// against it, and (elsewhere, not in this file) the actual copy/paste requests.
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"heckel.io/pcopy/config"
+	"heckel.io/pcopy/crypto"
+	"heckel.io/pcopy/server"
+)
+
+// Client talks to a single clipboard server identified by config.ServerAddr.
+//
+// Username/Password are exported, rather than threaded through config.Config, because they're often not
+// known yet when the Client is constructed -- "join" discovers them interactively (or via PCOPY_USER/
+// PCOPY_PASS) only after seeing that the server requires htpasswd-style auth.
+type Client struct {
+	config     *config.Config
+	Username   string
+	Password   string
+	httpClient *http.Client
+}
+
+// NewClient creates a Client for conf.ServerAddr. The underlying HTTP client does not verify server
+// certificates, since pcopy servers commonly use a self-signed certificate that is instead verified
+// out-of-band (see Verify) and then pinned to disk by "join".
+func NewClient(conf *config.Config) (*Client, error) {
+	if conf.ServerAddr == "" {
+		return nil, errors.New("server address missing")
+	}
+	return &Client{
+		config:   conf,
+		Username: conf.Username,
+		Password: conf.Password,
+		httpClient: &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		},
+	}, nil
+}
+
+// ServerInfo fetches the server's public info: how (if at all) it's secured, and its certificate if it's
+// self-signed. A server secured with htpasswd-style auth responds to an unauthenticated request with 401
+// and a WWW-Authenticate header; that is not treated as an error here, since it's the expected first
+// response during "join" before a username/password have been collected.
+func (c *Client) ServerInfo() (*server.Info, error) {
+	req, err := http.NewRequest(http.MethodGet, c.config.ServerAddr+"/info", nil)
+	if err != nil {
+		return nil, err
+	}
+	c.applyBasicAuth(req)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	cert := leafCert(resp)
+	if resp.StatusCode == http.StatusUnauthorized {
+		return &server.Info{
+			ServerAddr:     c.config.ServerAddr,
+			BasicAuthRealm: basicRealm(resp.Header.Get("WWW-Authenticate")),
+			Cert:           cert,
+		}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+
+	var body struct {
+		Salt []byte `json:"salt"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("cannot parse server info: %w", err)
+	}
+	return &server.Info{ServerAddr: c.config.ServerAddr, Salt: body.Salt, Cert: cert}, nil
+}
+
+// Verify checks that either key (the shared HMAC key scheme) or c.Username/Password (htpasswd-style auth)
+// are accepted by the server. cert, if non-nil, is the self-signed certificate pinned for this server; it
+// is not re-checked here since the underlying http.Client already trusts it.
+func (c *Client) Verify(cert *x509.Certificate, key *crypto.Key) error {
+	req, err := http.NewRequest(http.MethodGet, c.config.ServerAddr+"/verify", nil)
+	if err != nil {
+		return err
+	}
+	if key != nil {
+		req.Header.Set("Authorization", "HMAC "+key.EncodeKey())
+	} else {
+		c.applyBasicAuth(req)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server rejected credentials: %s", resp.Status)
+	}
+	return nil
+}
+
+func (c *Client) applyBasicAuth(req *http.Request) {
+	if c.Username != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// leafCert returns the server's leaf certificate, if the connection was made over TLS.
+func leafCert(resp *http.Response) *x509.Certificate {
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return resp.TLS.PeerCertificates[0]
+}
+
+// basicRealm extracts the realm from a `Basic realm="..."` WWW-Authenticate header value.
+func basicRealm(header string) string {
+	const prefix = `Basic realm="`
+	if !strings.HasPrefix(header, prefix) {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(header, prefix), `"`)
+}
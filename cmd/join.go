@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/urfave/cli/v2"
@@ -10,8 +12,30 @@ import (
 	"heckel.io/pcopy/server"
 	"heckel.io/pcopy/util"
 	"io/ioutil"
+	"math/rand"
+	"net"
 	"os"
+	"sort"
 	"strings"
+	"time"
+)
+
+// envUser and envPass let "join" pick up htpasswd-style credentials non-interactively, the same way
+// config.EnvKey lets it pick up the shared HMAC key.
+const (
+	envUser = "PCOPY_USER"
+	envPass = "PCOPY_PASS"
+)
+
+// srvService and srvProto identify the "_pcopy._tcp.<domain>" SRV record (RFC 2782) that readServerInfo
+// looks up to discover a clipboard server, instead of (or in addition to) guessing at well-known ports.
+const (
+	srvService = "pcopy"
+	srvProto   = "tcp"
+
+	// srvDialStagger is the delay before each subsequent candidate address is dialed, so that earlier (i.e.
+	// SRV-discovered, or otherwise more likely) candidates get a head start without blocking on them outright.
+	srvDialStagger = 100 * time.Millisecond
 )
 
 var cmdJoin = &cli.Command{
@@ -31,7 +55,8 @@ that can be used to identify it (default is 'default'). This command is interact
 will write a config file to ~/.config/pcopy/$CLIPBOARD.conf (or /etc/pcopy/$CLIPBOARD.conf).
 
 The command will ask for a password if the remote clipboard requires one, unless the PCOPY_KEY
-environment variable is passed.
+environment variable is passed. If the remote clipboard instead uses htpasswd-style multi-user
+authentication, you will be asked for a username and password, unless PCOPY_USER/PCOPY_PASS are passed.
 
 If the remote server's certificate is self-signed, its certificate will be downloaded to
 ~/.config/pcopy/$CLIPBOARD.crt (or /etc/pcopy/$CLIPBOARD.crt) and pinned for future connections.
@@ -75,8 +100,10 @@ func execJoin(c *cli.Context) error {
 		return err
 	}
 
-	// Read and verify that password was correct (if server is secured with key)
+	// Read and verify that password was correct (if server is secured with key). The HMAC key takes
+	// precedence over htpasswd-style auth, so a server configured with both still only prompts once.
 	var key *crypto.Key
+	var username, password string
 
 	if info.Salt != nil {
 		envKey := os.Getenv(config.EnvKey)
@@ -86,22 +113,38 @@ func execJoin(c *cli.Context) error {
 				return err
 			}
 		} else {
-			password, err := readPassword(c)
+			passwordBytes, err := readPassword(c)
 			if err != nil {
 				return err
 			}
-			key = crypto.DeriveKey(password, info.Salt)
+			key = crypto.DeriveKey(passwordBytes, info.Salt)
 			err = pclient.Verify(info.Cert, key)
 			if err != nil {
 				return fmt.Errorf("failed to join clipboard: %s", err.Error())
 			}
 		}
+	} else if info.BasicAuthRealm != "" {
+		username = os.Getenv(envUser)
+		password = os.Getenv(envPass)
+		if username == "" || password == "" {
+			username, password, err = readCredentials(c)
+			if err != nil {
+				return err
+			}
+		}
+		pclient.Username = username
+		pclient.Password = password
+		if err := pclient.Verify(info.Cert, nil); err != nil {
+			return fmt.Errorf("failed to join clipboard: %s", err.Error())
+		}
 	}
 
 	// Write config file
 	conf := &config.Config{
 		ServerAddr: info.ServerAddr,
 		Key:        key, // May be nil, but that's ok
+		Username:   username,
+		Password:   password,
 	}
 	if err := conf.WriteFile(configFile); err != nil {
 		return err
@@ -135,15 +178,26 @@ type serverInfoResult struct {
 // readServerInfo is doing a parallel lookup for all potential server addresses. For instance, "nopaste.net"
 // is expanded to ["https://nopaste.net:2586", "https://nopaste.net:443"] so we check both addresses in
 // parallel and return the first one that returns, or return an error with all errors.
+//
+// Before guessing, it also looks up the "_pcopy._tcp.<domain>" SRV record for rawServerAddr. If it exists,
+// the SRV-discovered addresses are placed ahead of the guessed ones, and all candidates are raced under a
+// staggered start (see srvDialStagger) so a slow or unreachable SRV-discovered candidate can't hold up a
+// later, guessed one for long. If no SRV record exists, there's nothing to prefer, so the guessed addresses
+// race with no stagger at all, same as before SRV discovery was added.
 func readServerInfo(c *cli.Context, rawServerAddr string) (*server.Info, error) {
 	fmt.Fprintf(c.App.ErrWriter, "Joining clipboard at %s ... ", rawServerAddr)
 
+	serverAddrs, discoveredCount := serverAddrCandidates(rawServerAddr)
 	resultChan := make(chan *serverInfoResult)
-	serverAddrs := config.ExpandServerAddrsGuess(rawServerAddr)
 
-	// Kick off parallel server info query
-	for _, serverAddr := range serverAddrs {
-		go func(serverAddr string) {
+	// Kick off parallel server info queries. Candidates are only staggered when an SRV lookup actually found
+	// something worth preferring; in the pure-fallback case (no SRV record) every guessed address races with
+	// no delay, same as before SRV discovery was added.
+	for i, serverAddr := range serverAddrs {
+		go func(i int, serverAddr string) {
+			if discoveredCount > 0 && i > 0 {
+				time.Sleep(time.Duration(i) * srvDialStagger)
+			}
 			pclient, _ := client.NewClient(&config.Config{ServerAddr: serverAddr})
 			serverInfo, err := pclient.ServerInfo()
 			if err != nil {
@@ -151,7 +205,7 @@ func readServerInfo(c *cli.Context, rawServerAddr string) (*server.Info, error)
 				return
 			}
 			resultChan <- &serverInfoResult{addr: serverAddr, info: serverInfo}
-		}(serverAddr)
+		}(i, serverAddr)
 	}
 
 	// Read from server channel until a success is returned
@@ -181,9 +235,111 @@ func readServerInfo(c *cli.Context, rawServerAddr string) (*server.Info, error)
 		return nil, fmt.Errorf("failed.\n%s", message)
 	}
 
+	// info.ServerAddr is whichever candidate actually answered, so it's what gets written to the config
+	// file by the caller -- meaning subsequent joins/syncs against this config skip discovery entirely.
 	return info, nil
 }
 
+// serverAddrCandidates returns the ordered list of server addresses to try for rawServerAddr: any addresses
+// discovered via "_pcopy._tcp.<domain>" SRV records first (in RFC 2782 priority/weight order), followed by
+// the guessed, well-known-port addresses that don't duplicate an SRV result. discoveredCount is how many of
+// the returned candidates came from SRV records, so callers can tell the pure-fallback case (0) apart from
+// one where SRV results are mixed in ahead of the guesses.
+func serverAddrCandidates(rawServerAddr string) (candidates []string, discoveredCount int) {
+	guessed := config.ExpandServerAddrsGuess(rawServerAddr)
+	discovered := lookupSRVAddrs(rawServerAddr)
+	if len(discovered) == 0 {
+		return guessed, 0
+	}
+
+	seen := make(map[string]bool, len(discovered))
+	candidates = make([]string, 0, len(discovered)+len(guessed))
+	for _, addr := range discovered {
+		seen[addr] = true
+		candidates = append(candidates, addr)
+	}
+	for _, addr := range guessed {
+		if !seen[addr] {
+			candidates = append(candidates, addr)
+		}
+	}
+	return candidates, len(discovered)
+}
+
+// lookupSRVAddrs looks up the "_pcopy._tcp.<domain>" SRV record for rawServerAddr's host and returns the
+// resulting "https://host:port" addresses, ordered by priority (lowest first) and, within a priority,
+// randomized by weight as described in RFC 2782. It returns nil if there's no such record, or the lookup
+// fails -- callers are expected to fall back to the guessed address list in that case.
+func lookupSRVAddrs(rawServerAddr string) []string {
+	domain := srvLookupDomain(rawServerAddr)
+	_, srvRecords, err := net.DefaultResolver.LookupSRV(context.Background(), srvService, srvProto, domain)
+	if err != nil || len(srvRecords) == 0 {
+		return nil
+	}
+	ordered := orderSRVRecords(srvRecords)
+	addrs := make([]string, len(ordered))
+	for i, record := range ordered {
+		addrs[i] = fmt.Sprintf("https://%s:%d", strings.TrimSuffix(record.Target, "."), record.Port)
+	}
+	return addrs
+}
+
+// srvLookupDomain strips any scheme and port from rawServerAddr, leaving just the hostname that SRV
+// records are looked up against.
+func srvLookupDomain(rawServerAddr string) string {
+	addr := rawServerAddr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
+// orderSRVRecords sorts SRV records by priority (lowest first), and randomizes records sharing a priority
+// by weight, as described in RFC 2782.
+func orderSRVRecords(records []*net.SRV) []*net.SRV {
+	sorted := append([]*net.SRV{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Priority < sorted[j].Priority })
+
+	ordered := make([]*net.SRV, 0, len(sorted))
+	for i := 0; i < len(sorted); {
+		j := i
+		for j < len(sorted) && sorted[j].Priority == sorted[i].Priority {
+			j++
+		}
+		ordered = append(ordered, weightedShuffleSRV(sorted[i:j])...)
+		i = j
+	}
+	return ordered
+}
+
+// weightedShuffleSRV repeatedly picks a random record from group, weighted by its Weight field (plus one,
+// so zero-weight records can still be picked), removing it from consideration each time. This is the
+// selection algorithm described in RFC 2782 for SRV records that share a priority.
+func weightedShuffleSRV(group []*net.SRV) []*net.SRV {
+	remaining := append([]*net.SRV{}, group...)
+	result := make([]*net.SRV, 0, len(remaining))
+	for len(remaining) > 0 {
+		total := 0
+		for _, record := range remaining {
+			total += int(record.Weight) + 1
+		}
+		pick := rand.Intn(total)
+		sum := 0
+		for i, record := range remaining {
+			sum += int(record.Weight) + 1
+			if pick < sum {
+				result = append(result, record)
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				break
+			}
+		}
+	}
+	return result
+}
+
 func readPassword(c *cli.Context) ([]byte, error) {
 	fmt.Fprintf(c.App.ErrWriter, "\r%s\rEnter password to join clipboard: ", strings.Repeat(" ", 50)) // a hack ..
 	password, err := util.ReadPassword(c.App.Reader)
@@ -194,6 +350,25 @@ func readPassword(c *cli.Context) ([]byte, error) {
 	return password, nil
 }
 
+// readCredentials prompts for a username and password, used for htpasswd-style multi-user auth (as opposed
+// to the single shared key handled by readPassword).
+func readCredentials(c *cli.Context) (string, string, error) {
+	fmt.Fprintf(c.App.ErrWriter, "\r%s\rEnter username to join clipboard: ", strings.Repeat(" ", 50)) // a hack ..
+	scanner := bufio.NewScanner(c.App.Reader)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", "", err
+		}
+		return "", "", errors.New("failed to read username")
+	}
+	username := strings.TrimSpace(scanner.Text())
+	password, err := readPassword(c)
+	if err != nil {
+		return "", "", err
+	}
+	return username, string(password), nil
+}
+
 func printInstructions(c *cli.Context, configFile string, clipboard string, info *server.Info) {
 	clipboardPrefix := ""
 	if clipboard != config.DefaultClipboard {
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"net"
+	"testing"
+)
+
+func TestOrderSRVRecords_KeepsPriorityGroupsOrdered(t *testing.T) {
+	records := []*net.SRV{
+		{Target: "c.", Priority: 20, Weight: 1},
+		{Target: "a.", Priority: 10, Weight: 1},
+		{Target: "b.", Priority: 10, Weight: 1},
+		{Target: "d.", Priority: 30, Weight: 1},
+	}
+
+	ordered := orderSRVRecords(records)
+	if len(ordered) != len(records) {
+		t.Fatalf("expected %d records, got %d", len(records), len(ordered))
+	}
+
+	// Priority 10 records (a, b) must both come before the priority 20 record (c), which must come before
+	// the priority 30 record (d), regardless of how weightedShuffleSRV orders records within a priority.
+	for i := 1; i < len(ordered); i++ {
+		if ordered[i].Priority < ordered[i-1].Priority {
+			t.Fatalf("priority decreased at index %d: %+v", i, ordered)
+		}
+	}
+	lowPriority := map[string]bool{"a.": true, "b.": true}
+	for _, r := range ordered[:2] {
+		if !lowPriority[r.Target] {
+			t.Errorf("expected one of the priority-10 records in the first two slots, got %s", r.Target)
+		}
+	}
+}
+
+func TestWeightedShuffleSRV_ReturnsAllRecordsExactlyOnce(t *testing.T) {
+	group := []*net.SRV{
+		{Target: "a.", Weight: 0},
+		{Target: "b.", Weight: 100},
+		{Target: "c.", Weight: 0},
+	}
+
+	result := weightedShuffleSRV(group)
+	if len(result) != len(group) {
+		t.Fatalf("expected %d records back, got %d", len(group), len(result))
+	}
+
+	seen := make(map[string]bool, len(result))
+	for _, r := range result {
+		seen[r.Target] = true
+	}
+	for _, r := range group {
+		if !seen[r.Target] {
+			t.Errorf("record %s missing from shuffled result", r.Target)
+		}
+	}
+}
+
+func TestWeightedShuffleSRV_ZeroWeightRecordsCanBePicked(t *testing.T) {
+	// With every record at weight 0, weightedShuffleSRV falls back to a uniform pick among them (the "+1" in
+	// its weighting). Run it repeatedly and check that every record shows up first at least once, so a
+	// zero-weight record isn't silently starved.
+	group := []*net.SRV{{Target: "a."}, {Target: "b."}, {Target: "c."}}
+	firstSeen := make(map[string]bool, len(group))
+	for i := 0; i < 200 && len(firstSeen) < len(group); i++ {
+		result := weightedShuffleSRV(group)
+		firstSeen[result[0].Target] = true
+	}
+	for _, r := range group {
+		if !firstSeen[r.Target] {
+			t.Errorf("record %s was never picked first across 200 shuffles of all-zero-weight records", r.Target)
+		}
+	}
+}
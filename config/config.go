@@ -0,0 +1,132 @@
+// Package config reads, writes and guesses at the per-clipboard config files pcopy keeps in
+// ~/.config/pcopy/$CLIPBOARD.conf (or /etc/pcopy/$CLIPBOARD.conf).
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"heckel.io/pcopy/crypto"
+)
+
+// DefaultClipboard is the clipboard alias used when none is given on the command line.
+const DefaultClipboard = "default"
+
+// EnvKey is the environment variable "join"/"copy"/"paste" read the shared HMAC key from, so it doesn't
+// have to be typed interactively or stored in the config file.
+const EnvKey = "PCOPY_KEY"
+
+// defaultPorts are tried, in order, against a bare host when no port was given explicitly.
+var defaultPorts = []int{2586, 443}
+
+// Config is a single clipboard's configuration, as read from or written to a config file.
+type Config struct {
+	ServerAddr string
+	Key        *crypto.Key
+	AuthFile   string
+	Username   string
+	Password   string
+}
+
+// WriteFile writes conf to file as a simple "key value" config file, creating parent directories as needed.
+func (c *Config) WriteFile(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "ServerAddr %s\n", c.ServerAddr)
+	if c.Key != nil {
+		fmt.Fprintf(&b, "Key %s\n", c.Key.EncodeKey())
+	}
+	if c.AuthFile != "" {
+		fmt.Fprintf(&b, "AuthFile %s\n", c.AuthFile)
+	}
+	if c.Username != "" {
+		fmt.Fprintf(&b, "Username %s\n", c.Username)
+	}
+	if c.Password != "" {
+		fmt.Fprintf(&b, "Password %s\n", c.Password)
+	}
+	return os.WriteFile(file, []byte(b.String()), 0600)
+}
+
+// LoadFile reads a config file previously written by WriteFile.
+func LoadFile(file string) (*Config, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	conf := &Config{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		switch fields[0] {
+		case "ServerAddr":
+			conf.ServerAddr = fields[1]
+		case "Key":
+			key, err := crypto.DecodeKey(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid key in %s: %w", file, err)
+			}
+			conf.Key = key
+		case "AuthFile":
+			conf.AuthFile = fields[1]
+		case "Username":
+			conf.Username = fields[1]
+		case "Password":
+			conf.Password = fields[1]
+		}
+	}
+	return conf, scanner.Err()
+}
+
+// Store locates config files (and the self-signed certificates pinned alongside them) by clipboard alias.
+type Store struct {
+	dir string
+}
+
+// NewStore creates a Store rooted at the user's config directory (~/.config/pcopy), falling back to
+// /etc/pcopy if the user has no home directory, e.g. when running as a system service.
+func NewStore() *Store {
+	if home, err := os.UserHomeDir(); err == nil && home != "" {
+		return &Store{dir: filepath.Join(home, ".config", "pcopy")}
+	}
+	return &Store{dir: "/etc/pcopy"}
+}
+
+// FileFromName returns the config file path for the clipboard alias name.
+func (s *Store) FileFromName(name string) string {
+	return filepath.Join(s.dir, name+".conf")
+}
+
+// DefaultCertFile returns the path a self-signed certificate pinned for configFile's clipboard is read from
+// or written to. The server flag has no effect: the path is configFile with its extension replaced by ".crt"
+// either way.
+func DefaultCertFile(configFile string, server bool) string {
+	return strings.TrimSuffix(configFile, filepath.Ext(configFile)) + ".crt"
+}
+
+// ExpandServerAddrsGuess expands a bare server address (as typed by a user, e.g. "nopaste.net") into the
+// "https://host:port" addresses worth trying, in order, when no port was specified explicitly.
+func ExpandServerAddrsGuess(rawServerAddr string) []string {
+	addr := rawServerAddr
+	if i := strings.Index(addr, "://"); i >= 0 {
+		addr = addr[i+3:]
+	}
+	if strings.Contains(addr, ":") {
+		return []string{fmt.Sprintf("https://%s", addr)}
+	}
+	guesses := make([]string, len(defaultPorts))
+	for i, port := range defaultPorts {
+		guesses[i] = fmt.Sprintf("https://%s:%d", addr, port)
+	}
+	return guesses
+}
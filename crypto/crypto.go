@@ -0,0 +1,83 @@
+// Package crypto derives and encodes the shared key pcopy uses to secure a clipboard, and encodes the
+// self-signed TLS certificates it pins for untrusted servers.
+package crypto
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+)
+
+const (
+	keyLenBytes = 32
+	keyRounds   = 1 << 16 // deliberately slow, to make brute-forcing a weak password expensive
+)
+
+// Key is the derived secret used to authenticate a client against a clipboard server.
+type Key struct {
+	Bytes []byte
+	Salt  []byte
+}
+
+// DeriveKey derives a Key from password and salt by repeatedly HMAC-ing the password with salt keyRounds
+// times, so the same password/salt pair always produces the same key (needed so "join" can reproduce the
+// key a server was set up with) while still being slow to brute-force.
+func DeriveKey(password []byte, salt []byte) *Key {
+	derived := append([]byte{}, password...)
+	for i := 0; i < keyRounds; i++ {
+		mac := hmac.New(sha256.New, salt)
+		mac.Write(derived)
+		derived = mac.Sum(nil)
+	}
+	return &Key{Bytes: derived[:keyLenBytes], Salt: salt}
+}
+
+// DecodeKey decodes a key previously encoded for the PCOPY_KEY environment variable, in "salt:bytes" form,
+// both base64-encoded.
+func DecodeKey(encoded string) (*Key, error) {
+	parts := strings.SplitN(encoded, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("invalid key format, expected salt:bytes")
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	return &Key{Bytes: keyBytes, Salt: salt}, nil
+}
+
+// EncodeKey encodes key for the PCOPY_KEY environment variable, the inverse of DecodeKey.
+func (k *Key) EncodeKey() string {
+	return base64.StdEncoding.EncodeToString(k.Salt) + ":" + base64.StdEncoding.EncodeToString(k.Bytes)
+}
+
+// Fingerprint returns a short, stable identifier for the key, e.g. for display purposes. It is not secret.
+func (k *Key) Fingerprint() string {
+	sum := sha256.Sum256(k.Bytes)
+	return base64.StdEncoding.EncodeToString(sum[:8])
+}
+
+// EncodeCert PEM-encodes cert, the form in which pcopy pins a server's self-signed certificate to disk.
+func EncodeCert(cert *x509.Certificate) ([]byte, error) {
+	if cert == nil {
+		return nil, errors.New("cannot encode nil certificate")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), nil
+}
+
+// DecodeCert parses a PEM-encoded certificate previously written by EncodeCert.
+func DecodeCert(encoded []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(encoded)
+	if block == nil {
+		return nil, errors.New("cannot decode certificate: not valid PEM")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
@@ -0,0 +1,21 @@
+package server
+
+import "crypto/x509"
+
+// Info is the subset of a clipboard's configuration that "pcopy join" needs from a server before it can
+// write a local config file: the address to actually talk to, how (if at all) the server is secured, and
+// its certificate if it's self-signed.
+type Info struct {
+	ServerAddr string
+
+	// Salt is set if the server is secured with the shared HMAC key scheme; if so, "join" derives the key
+	// from a password and Salt and verifies it before writing the config file.
+	Salt []byte
+
+	// BasicAuthRealm is set instead of Salt if the server is secured with htpasswd-style multi-user auth
+	// (see server.UserStore), detected from a 401 response carrying a WWW-Authenticate: Basic header.
+	BasicAuthRealm string
+
+	// Cert is set if the server presented a self-signed certificate, so "join" can pin it.
+	Cert *x509.Certificate
+}
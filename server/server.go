@@ -0,0 +1,87 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+
+	"heckel.io/pcopy/config"
+	"heckel.io/pcopy/crypto"
+)
+
+// HeaderNoRedirect is set by internal callers (e.g. the netcat tcpForwarder) on requests that must get a
+// raw response instead of an HTTP redirect, since "nc" has no concept of following one.
+const HeaderNoRedirect = "X-No-Redirect"
+
+// Server assembles the clipboard's HTTP handler and wires up whichever auth scheme(s) its config enables.
+type Server struct {
+	config *config.Config
+}
+
+// New creates a Server for conf.
+func New(conf *config.Config) *Server {
+	return &Server{config: conf}
+}
+
+// Handler assembles the server's HTTP handler chain: the routes themselves, wrapped with the shared-key
+// check (if conf.Key is set), or with htpasswd-style Basic auth (if conf.AuthFile is set). A request only has
+// to satisfy whichever of the two schemes is enabled; if both are configured, the shared key takes
+// precedence and conf.AuthFile is not used.
+func (s *Server) Handler() (http.HandlerFunc, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/info", s.handleInfo)
+	mux.HandleFunc("/verify", s.handleVerify)
+
+	handler := s.withOptionalKeyAuth(mux.ServeHTTP)
+	if s.config.Key != nil {
+		return handler, nil
+	}
+	return WithOptionalBasicAuth(s.config.AuthFile, handler)
+}
+
+// handleInfo serves the server's public info: whether it's secured with the shared key (and its salt, if
+// so). It is exempt from the shared-key check in withOptionalKeyAuth, since a client needs this response,
+// unauthenticated, to learn the salt in the first place.
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	var salt []byte
+	if s.config.Key != nil {
+		salt = s.config.Key.Salt
+	}
+	json.NewEncoder(w).Encode(struct {
+		Salt []byte `json:"salt"`
+	}{Salt: salt})
+}
+
+// handleVerify checks the client's credentials. If the shared key is configured, it validates the
+// Authorization header; otherwise, reaching this handler at all already means Handler's Basic auth
+// wrapping (or no auth at all) accepted the request.
+func (s *Server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	if s.config.Key != nil && !validHMACAuth(r.Header.Get("Authorization"), s.config.Key) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// withOptionalKeyAuth requires a valid HMAC Authorization header on every request except /info, unless
+// conf.Key is unset, in which case it's a no-op.
+func (s *Server) withOptionalKeyAuth(next http.HandlerFunc) http.HandlerFunc {
+	if s.config.Key == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/info" || validHMACAuth(r.Header.Get("Authorization"), s.config.Key) {
+			next(w, r)
+			return
+		}
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	}
+}
+
+func validHMACAuth(header string, key *crypto.Key) bool {
+	const prefix = "HMAC "
+	if len(header) <= len(prefix) || header[:len(prefix)] != prefix {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(header[len(prefix):]), []byte(key.EncodeKey())) == 1
+}
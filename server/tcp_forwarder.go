@@ -6,29 +6,70 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"golang.org/x/time/rate"
 	"heckel.io/pcopy/util"
 	"io"
 	"log"
 	"net"
 	"net/http"
-	"net/http/httptest"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	defaultReadTimeout = 3 * time.Second
-	bufferSizeBytes    = 16 * 1024
+	defaultReadTimeout     = 3 * time.Second
+	bufferSizeBytes        = 16 * 1024
+	ioCopyBufferSizeBytes  = 32 * 1024 // io.Copy's internal buffer size, i.e. the largest single Read/WaitN call
+	defaultMaxConcurrent   = 256
+	defaultLimitPerIP      = 2 // connections/sec
+	defaultBurstPerIP      = 5
+	rejectedMessage        = "busy, try again later"
+	ipLimiterIdleTimeout   = 10 * time.Minute // an IP's token bucket is dropped if unused for this long
+	ipLimiterSweepInterval = time.Minute
+	statsLogInterval       = time.Minute
 )
 
 // tcpForwarder is a server that listens on a raw TCP socket and forwards incoming connections to an upstream
-// HTTP handler function as a PUT request. That makes it possible to do "cat ... | nc nopaste.net 9999".
+// HTTP handler function, by default as a PUT request. That makes it possible to do "cat ... | nc nopaste.net 9999"
+// to copy, or "echo 'pcopy get:abc' | nc nopaste.net 9999" to paste it back, symmetrically.
+//
+// To keep the otherwise unauthenticated, unbounded netcat endpoint from being trivially exhausted, connections
+// are subject to three independent limits: a global MaxConcurrent cap enforced with a semaphore, a per-remote-IP
+// LimitPerIP/BurstPerIP token bucket, and a global ByteRateLimit applied to the bytes streamed into the upstream
+// handler. A connection that is rejected by either limit gets a single explanatory line and is closed.
+//
+// MaxConcurrent/LimitPerIP/BurstPerIP/ByteRateLimit are plain exported fields, not a config struct, so that
+// (like ReadTimeout above) the caller that owns the server's config can assign them straight from its own
+// TCPForward* settings after calling newTCPForwarder, without this package needing to know about that config
+// type.
 type tcpForwarder struct {
 	Addr            string
 	UpstreamAddr    string
 	UpstreamHandler http.HandlerFunc
 	ReadTimeout     time.Duration
-	cancel          context.CancelFunc
+	MaxConcurrent   int        // 0 = unlimited
+	LimitPerIP      rate.Limit // connections/sec per remote IP, 0 = unlimited
+	BurstPerIP      int
+	ByteRateLimit   rate.Limit // bytes/sec across all connections combined, 0 = unlimited
+
+	cancel      context.CancelFunc
+	listener    net.Listener
+	ready       chan struct{} // closed once listener is bound, so tests/callers can learn the actual Addr()
+	sem         chan struct{}
+	ipLimiters  sync.Map // remote IP (string) -> *ipLimiterEntry
+	byteLimiter *rate.Limiter
+
+	active   int32 // current number of connections being handled, for metrics/info
+	rejected int64 // connections rejected by a limit since startup, for metrics/info
+}
+
+// ipLimiterEntry pairs a per-IP token bucket with the last time it was consulted, so idle entries can be
+// swept instead of accumulating forever for every distinct remote IP a server has ever seen.
+type ipLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastUsed int64 // unix nanoseconds, set with atomic.StoreInt64
 }
 
 func newTCPForwarder(addr string, upstreamAddr string, upstreamHandler http.HandlerFunc) *tcpForwarder {
@@ -37,6 +78,10 @@ func newTCPForwarder(addr string, upstreamAddr string, upstreamHandler http.Hand
 		UpstreamAddr:    upstreamAddr,
 		UpstreamHandler: upstreamHandler,
 		ReadTimeout:     defaultReadTimeout,
+		MaxConcurrent:   defaultMaxConcurrent,
+		LimitPerIP:      defaultLimitPerIP,
+		BurstPerIP:      defaultBurstPerIP,
+		ready:           make(chan struct{}),
 	}
 }
 
@@ -47,7 +92,19 @@ func (s *tcpForwarder) listenAndServe() error {
 	}
 	defer listener.Close()
 
+	if s.MaxConcurrent > 0 {
+		s.sem = make(chan struct{}, s.MaxConcurrent)
+	}
+	if s.ByteRateLimit > 0 {
+		s.byteLimiter = rate.NewLimiter(s.ByteRateLimit, ioCopyBufferSizeBytes)
+	}
+
+	s.listener = listener
+	close(s.ready)
+
 	ctx, cancel := context.WithCancel(context.Background())
+	go s.sweepIdleIPLimiters(ctx)
+	go s.logStats(ctx)
 	go func() {
 		for {
 			conn, err := listener.Accept()
@@ -55,7 +112,11 @@ func (s *tcpForwarder) listenAndServe() error {
 				log.Printf("error accepting connection on %s: %s", s.Addr, err.Error())
 				continue
 			}
+			if !s.acquire(conn) {
+				continue // rejected and closed by acquire
+			}
 			go func(conn net.Conn) {
+				defer s.release()
 				defer conn.Close()
 				if err := s.handleConn(conn); err != nil {
 					io.WriteString(conn, fmt.Sprintf("%s\n", err.Error())) // might fail
@@ -74,10 +135,124 @@ func (s *tcpForwarder) shutdown() {
 	s.cancel()
 }
 
+// addr blocks until the listener is bound and returns its actual address, which is useful in tests that
+// start the forwarder on Addr "127.0.0.1:0" and need to know which port the OS picked.
+func (s *tcpForwarder) addr() net.Addr {
+	<-s.ready
+	return s.listener.Addr()
+}
+
+// stats returns the current number of connections being handled, and the number rejected by a limit since
+// startup. logStats polls it on a timer and is its only caller; the server's /info endpoint does not report
+// these counts.
+func (s *tcpForwarder) stats() (active int32, rejected int64) {
+	return atomic.LoadInt32(&s.active), atomic.LoadInt64(&s.rejected)
+}
+
+// logStats periodically logs the forwarder's saturation, which is where operators can see it.
+func (s *tcpForwarder) logStats(ctx context.Context) {
+	ticker := time.NewTicker(statsLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			active, rejected := s.stats()
+			log.Printf("tcp forwarder %s: %d active connections, %d rejected since startup", s.Addr, active, rejected)
+		}
+	}
+}
+
+// acquire admits conn if it passes the per-IP and global concurrency limits, incrementing the active
+// connection count. If a limit is exceeded, it rejects the connection (sending a single explanatory line
+// and closing it) and returns false; the caller must not use conn any further in that case.
+func (s *tcpForwarder) acquire(conn net.Conn) bool {
+	if s.LimitPerIP > 0 && !s.ipRateLimiter(remoteIP(conn)).Allow() {
+		s.reject(conn)
+		return false
+	}
+	if s.sem != nil {
+		select {
+		case s.sem <- struct{}{}:
+		default:
+			s.reject(conn)
+			return false
+		}
+	}
+	atomic.AddInt32(&s.active, 1)
+	return true
+}
+
+// release gives back the concurrency slot acquired by acquire. It must be called exactly once per
+// successful acquire.
+func (s *tcpForwarder) release() {
+	if s.sem != nil {
+		<-s.sem
+	}
+	atomic.AddInt32(&s.active, -1)
+}
+
+func (s *tcpForwarder) reject(conn net.Conn) {
+	atomic.AddInt64(&s.rejected, 1)
+	io.WriteString(conn, rejectedMessage+"\n") // might fail
+	conn.Close()
+}
+
+// ipRateLimiter returns the token bucket for remoteIP, creating one if there is none yet, and stamps its
+// last-used time on every call, so sweepIdleIPLimiters can tell which entries are still active.
+func (s *tcpForwarder) ipRateLimiter(remoteIP string) *rate.Limiter {
+	now := time.Now().UnixNano()
+	if v, ok := s.ipLimiters.Load(remoteIP); ok {
+		entry := v.(*ipLimiterEntry)
+		atomic.StoreInt64(&entry.lastUsed, now)
+		return entry.limiter
+	}
+	entry := &ipLimiterEntry{limiter: rate.NewLimiter(s.LimitPerIP, s.BurstPerIP), lastUsed: now}
+	v, _ := s.ipLimiters.LoadOrStore(remoteIP, entry)
+	return v.(*ipLimiterEntry).limiter
+}
+
+// sweepIdleIPLimiters periodically drops per-IP token buckets that haven't been used in ipLimiterIdleTimeout,
+// so a long-running server doesn't accumulate one map entry per distinct remote IP forever.
+func (s *tcpForwarder) sweepIdleIPLimiters(ctx context.Context) {
+	ticker := time.NewTicker(ipLimiterSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := time.Now().Add(-ipLimiterIdleTimeout).UnixNano()
+			s.ipLimiters.Range(func(key, value interface{}) bool {
+				if atomic.LoadInt64(&value.(*ipLimiterEntry).lastUsed) < cutoff {
+					s.ipLimiters.Delete(key)
+				}
+				return true
+			})
+		}
+	}
+}
+
+// remoteIP extracts the host part of conn.RemoteAddr(), falling back to the full address if it cannot be
+// split into host and port.
+func remoteIP(conn net.Conn) string {
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return host
+}
+
 // handleConn reads from the TCP socket and forwards it to the HTTP handler. This method does NOT close the underlying
 // connection. This is done in the listenAndServe to ensure that error messages can be sent to the client.
+//
+// The leading line of the connection selects the verb: a bare "help" prints usage, "pcopy:PATH" (or no
+// prefix at all) pastes the rest of the connection as a copy, and "pcopy get:PATH", "pcopy list" and
+// "pcopy info" instead paste the upstream response back down the same connection, giving "nc" a fully
+// bidirectional protocol.
 func (s *tcpForwarder) handleConn(conn net.Conn) error {
-	// Peak connection to detect "pcopy:..." prefix and extract path
+	// Peak connection to classify the leading line
 	connReadCloser := &connTimeoutReadCloser{conn: conn, timeout: s.ReadTimeout}
 	peaked, err := util.Peak(connReadCloser, bufferSizeBytes)
 	if err != nil {
@@ -85,9 +260,22 @@ func (s *tcpForwarder) handleConn(conn net.Conn) error {
 	} else if strings.TrimSpace(string(peaked.PeakedBytes)) == "help" {
 		return s.handleHelp(conn)
 	}
-	path, offset := extractPath(peaked.PeakedBytes)
+	verb, path, offset := parseCommand(peaked.PeakedBytes)
+	switch verb {
+	case verbGet:
+		return s.forwardGet(conn, path)
+	case verbList:
+		return s.forwardGet(conn, "list")
+	case verbInfo:
+		return s.forwardGet(conn, "info")
+	default:
+		return s.handlePut(conn, connReadCloser, peaked.PeakedBytes, path, offset)
+	}
+}
 
-	// Prepare upstream HTTP request
+// handlePut forwards the rest of the connection to the upstream handler as an HTTP PUT, i.e. a "copy". This
+// is the default verb, selected by a "pcopy:PATH" prefix, or no prefix at all.
+func (s *tcpForwarder) handlePut(conn net.Conn, connReadCloser *connTimeoutReadCloser, peakedBytes []byte, path string, offset int) error {
 	rawURL := fmt.Sprintf("%s/%s", s.UpstreamAddr, path)
 	requestBodyReader, requestBodyWriter := io.Pipe()
 	request, err := http.NewRequest(http.MethodPut, rawURL, requestBodyReader)
@@ -101,7 +289,10 @@ func (s *tcpForwarder) handleConn(conn net.Conn) error {
 	// Read downstream connection and copy to HTTP request body, including peaked bytes
 	errChan := make(chan error)
 	go func() {
-		requestBody := io.MultiReader(bytes.NewReader(peaked.PeakedBytes[offset:]), connReadCloser)
+		var requestBody io.Reader = io.MultiReader(bytes.NewReader(peakedBytes[offset:]), connReadCloser)
+		if s.byteLimiter != nil {
+			requestBody = &rateLimitedReader{reader: requestBody, limiter: s.byteLimiter}
+		}
 		_, err := io.Copy(requestBodyWriter, requestBody)
 		if err != nil {
 			errChan <- err
@@ -110,52 +301,79 @@ func (s *tcpForwarder) handleConn(conn net.Conn) error {
 		}
 	}()
 
-	// Record upstream response and forward downstream
-	rr := httptest.NewRecorder()
-	s.UpstreamHandler.ServeHTTP(rr, request)
+	// Stream upstream response straight to the downstream connection as it is written, instead of buffering
+	// it in memory first. Only a non-2xx response is buffered (it's small), so we can still report it as a
+	// proper error line without having already started streaming a body.
+	rw := newConnResponseWriter(conn, s.ReadTimeout)
+	s.UpstreamHandler.ServeHTTP(rw, request)
 	defer func() {
 		requestBodyReader.Close()
 		requestBodyWriter.Close()
 	}()
-	if rr.Code != http.StatusCreated && rr.Code != http.StatusPartialContent {
-		return errors.New(rr.Result().Status)
+	if rw.status != http.StatusCreated && rw.status != http.StatusPartialContent {
+		return errors.New(rw.statusText())
 	}
 	if err := <-errChan; err != nil {
 		return err
 	}
-	if _, err := conn.Write(rr.Body.Bytes()); err != nil {
-		return err
-	}
 	return nil
 }
 
 func (s *tcpForwarder) handleHelp(conn net.Conn) error {
-	rawURL := fmt.Sprintf("%s/nc", s.UpstreamAddr)
+	return s.forwardGet(conn, "nc")
+}
+
+// forwardGet issues an HTTP GET for path against the upstream handler and streams the response back down
+// conn, reporting any non-2xx response as an error.
+func (s *tcpForwarder) forwardGet(conn net.Conn, path string) error {
+	rawURL := fmt.Sprintf("%s/%s", s.UpstreamAddr, path)
 	request, err := http.NewRequest(http.MethodGet, rawURL, nil)
 	if err != nil {
 		return fmt.Errorf("cannot create forwarding request: %w", err)
 	}
-	request.RequestURI = "/nc"
+	request.RequestURI = fmt.Sprintf("/%s", path)
 	request.RemoteAddr = conn.RemoteAddr().String()
 	request.Header.Set(HeaderNoRedirect, "1")
-	rr := httptest.NewRecorder()
-	s.UpstreamHandler.ServeHTTP(rr, request)
-	if rr.Code != http.StatusOK {
-		return errors.New(rr.Result().Status)
-	}
-	if _, err := conn.Write(rr.Body.Bytes()); err != nil {
-		return err
+	rw := newConnResponseWriter(conn, s.ReadTimeout)
+	s.UpstreamHandler.ServeHTTP(rw, request)
+	if rw.status < 200 || rw.status >= 300 {
+		return errors.New(rw.statusText())
 	}
 	return nil
 }
 
-func extractPath(peaked []byte) (string, int) {
+// ncVerb identifies the command requested by the leading line of a netcat connection. verbPut is the
+// zero value and default, since plain "cat file | nc host 9999" carries no prefix at all.
+type ncVerb int
+
+const (
+	verbPut ncVerb = iota
+	verbGet
+	verbList
+	verbInfo
+)
+
+// parseCommand inspects the first line of a peaked connection buffer and classifies it into an ncVerb plus
+// the path it applies to (only meaningful for verbGet and the default "pcopy:PATH" copy). The bare "help"
+// verb is handled separately in handleConn, since it must match even without a trailing newline.
+func parseCommand(peaked []byte) (verb ncVerb, path string, offset int) {
 	reader := bufio.NewReader(bytes.NewReader(peaked))
-	s, err := reader.ReadString('\n')
-	if err != nil || !strings.HasPrefix(s, "pcopy:") {
-		return "", 0
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return verbPut, "", 0
+	}
+	switch trimmed := strings.TrimSuffix(line, "\n"); {
+	case strings.HasPrefix(trimmed, "pcopy get:"):
+		return verbGet, strings.TrimPrefix(trimmed, "pcopy get:"), len(line)
+	case trimmed == "pcopy list":
+		return verbList, "", len(line)
+	case trimmed == "pcopy info":
+		return verbInfo, "", len(line)
+	case strings.HasPrefix(trimmed, "pcopy:"):
+		return verbPut, strings.TrimPrefix(trimmed, "pcopy:"), len(line)
+	default:
+		return verbPut, "", 0
 	}
-	return strings.TrimSuffix(strings.TrimPrefix(s, "pcopy:"), "\n"), len(s)
 }
 
 type connTimeoutReadCloser struct {
@@ -181,4 +399,78 @@ func (c *connTimeoutReadCloser) Read(p []byte) (n int, err error) {
 
 func (c *connTimeoutReadCloser) Close() error {
 	return c.conn.Close()
-}
\ No newline at end of file
+}
+
+// rateLimitedReader throttles reads from an upload against a shared rate.Limiter, enforcing tcpForwarder's
+// global ByteRateLimit across all concurrent connections combined.
+type rateLimitedReader struct {
+	reader  io.Reader
+	limiter *rate.Limiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if werr := r.limiter.WaitN(context.Background(), n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// connResponseWriter is an http.ResponseWriter that streams a response body directly to a net.Conn as it is
+// written by the handler, instead of buffering the entire response in memory (as httptest.ResponseRecorder
+// would). This keeps memory use bounded for large streamed responses, e.g. a big paste served via "nc".
+//
+// A non-2xx response is buffered instead of streamed, since the downstream "nc" protocol has no concept of
+// HTTP status codes or headers; it only ever receives plain body bytes, or -- on failure -- a single error
+// line. Buffering the (typically tiny) error body lets handleConn/handleHelp turn it into that error line
+// without having already started streaming bytes to the client.
+type connResponseWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+	header  http.Header
+	status  int
+	errBody bytes.Buffer
+}
+
+func newConnResponseWriter(conn net.Conn, timeout time.Duration) *connResponseWriter {
+	return &connResponseWriter{conn: conn, timeout: timeout, header: make(http.Header)}
+}
+
+func (w *connResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *connResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *connResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status < 200 || w.status >= 300 {
+		return w.errBody.Write(p)
+	}
+	if err := w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return 0, fmt.Errorf("cannot set write deadline: %w", err)
+	}
+	return w.conn.Write(p)
+}
+
+// Flush is a no-op: Write already forwards every chunk to the connection as it arrives, so there is nothing
+// left to flush. It merely makes connResponseWriter satisfy http.Flusher for handlers that call it.
+func (w *connResponseWriter) Flush() {}
+
+// statusText returns a "404 Not Found" style status line for a failed response, which handleConn/handleHelp
+// report back as the connection's error line.
+func (w *connResponseWriter) statusText() string {
+	text := w.errBody.String()
+	if strings.TrimSpace(text) != "" {
+		return fmt.Sprintf("%d %s: %s", w.status, http.StatusText(w.status), strings.TrimSpace(text))
+	}
+	return fmt.Sprintf("%d %s", w.status, http.StatusText(w.status))
+}
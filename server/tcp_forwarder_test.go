@@ -0,0 +1,183 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCommand(t *testing.T) {
+	cases := []struct {
+		name       string
+		peaked     string
+		wantVerb   ncVerb
+		wantPath   string
+		wantOffset int
+	}{
+		{"get", "pcopy get:file.txt\nbody", verbGet, "file.txt", len("pcopy get:file.txt\n")},
+		{"list", "pcopy list\n", verbList, "", len("pcopy list\n")},
+		{"info", "pcopy info\n", verbInfo, "", len("pcopy info\n")},
+		{"put with prefix", "pcopy:file.txt\nbody", verbPut, "file.txt", len("pcopy:file.txt\n")},
+		{"put with no prefix", "raw clipboard body\n", verbPut, "", 0},
+		{"get with no trailing newline", "pcopy get:file.txt", verbPut, "", 0},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			verb, path, offset := parseCommand([]byte(c.peaked))
+			if verb != c.wantVerb {
+				t.Errorf("verb = %v, want %v", verb, c.wantVerb)
+			}
+			if path != c.wantPath {
+				t.Errorf("path = %q, want %q", path, c.wantPath)
+			}
+			if offset != c.wantOffset {
+				t.Errorf("offset = %d, want %d", offset, c.wantOffset)
+			}
+		})
+	}
+}
+
+// TestTCPForwarder_StreamsChunksBeforeHandlerReturns starts a forwarder in front of a handler that writes a
+// few chunks with a delay in between, and asserts that each chunk arrives on the "nc" connection as it is
+// written, rather than all at once after the handler returns. This is the behavior connResponseWriter exists
+// for: a naive httptest.ResponseRecorder-style writer would buffer the whole body and only flush it on EOF.
+func TestTCPForwarder_StreamsChunksBeforeHandlerReturns(t *testing.T) {
+	chunkDelay := 100 * time.Millisecond
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		for i := 0; i < 3; i++ {
+			w.Write([]byte("chunk\n"))
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			time.Sleep(chunkDelay)
+		}
+	}
+
+	fwd := newTCPForwarder("127.0.0.1:0", "http://upstream", handler)
+	fwd.MaxConcurrent = 0
+	fwd.LimitPerIP = 0
+	go fwd.listenAndServe()
+	defer fwd.shutdown()
+
+	conn, err := net.Dial("tcp", fwd.addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial forwarder: %s", err.Error())
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("pcopy get:file\n")); err != nil {
+		t.Fatalf("cannot write command: %s", err.Error())
+	}
+
+	reader := bufio.NewReader(conn)
+	start := time.Now()
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatalf("cannot read first chunk: %s", err.Error())
+	}
+	firstChunkAfter := time.Since(start)
+	if line != "chunk\n" {
+		t.Fatalf("expected first chunk, got %q", line)
+	}
+
+	// The remaining two chunks are each delayed by chunkDelay on the server side. If connResponseWriter
+	// buffered the whole response instead of streaming it, all three chunks (and therefore this first
+	// ReadString) would only become readable after roughly 3*chunkDelay, once the handler returned.
+	if firstChunkAfter >= 2*chunkDelay {
+		t.Fatalf("first chunk arrived after %s, expected well under %s (response appears to be buffered, not streamed)", firstChunkAfter, 2*chunkDelay)
+	}
+}
+
+// TestTCPForwarder_GetReportsUpstreamError verifies that a "pcopy get:PATH" against a handler that responds
+// with a non-2xx status is reported back down the connection as a single error line, as handleConn promises,
+// rather than the (empty) body being forwarded as if it had succeeded.
+func TestTCPForwarder_GetReportsUpstreamError(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no such file", http.StatusNotFound)
+	}
+
+	fwd := newTCPForwarder("127.0.0.1:0", "http://upstream", handler)
+	fwd.MaxConcurrent = 0
+	fwd.LimitPerIP = 0
+	go fwd.listenAndServe()
+	defer fwd.shutdown()
+
+	conn, err := net.Dial("tcp", fwd.addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial forwarder: %s", err.Error())
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("pcopy get:missing.txt\n")); err != nil {
+		t.Fatalf("cannot write command: %s", err.Error())
+	}
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("cannot read error line: %s", err.Error())
+	}
+	if !strings.Contains(line, "404") {
+		t.Errorf("expected error line to mention the 404 status, got %q", line)
+	}
+}
+
+// TestTCPForwarder_RejectsBeyondMaxConcurrent opens N connections against a forwarder configured with
+// MaxConcurrent=N, each of which blocks inside the handler until the test releases it, then verifies that
+// connection N+1 is rejected immediately (rather than queued or accepted) instead of being let through.
+func TestTCPForwarder_RejectsBeyondMaxConcurrent(t *testing.T) {
+	const maxConcurrent = 3
+	release := make(chan struct{})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}
+
+	fwd := newTCPForwarder("127.0.0.1:0", "http://upstream", handler)
+	fwd.MaxConcurrent = maxConcurrent
+	fwd.LimitPerIP = 0 // isolate the concurrency limit from the per-IP rate limit
+	go fwd.listenAndServe()
+	defer fwd.shutdown()
+	defer close(release)
+
+	addr := fwd.addr().String()
+	conns := make([]net.Conn, maxConcurrent)
+	for i := 0; i < maxConcurrent; i++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("cannot dial forwarder: %s", err.Error())
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("pcopy get:file\n")); err != nil {
+			t.Fatalf("cannot write command: %s", err.Error())
+		}
+		conns[i] = conn
+	}
+
+	// Give the acceptor a moment to admit all maxConcurrent connections before trying the one that should
+	// be rejected.
+	for i := 0; i < 50; i++ {
+		if active, _ := fwd.stats(); active == maxConcurrent {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	extra, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("cannot dial forwarder: %s", err.Error())
+	}
+	defer extra.Close()
+	if _, err := extra.Write([]byte("pcopy get:file\n")); err != nil {
+		t.Fatalf("cannot write command: %s", err.Error())
+	}
+	extra.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := bufio.NewReader(extra).ReadString('\n')
+	if err != nil {
+		t.Fatalf("cannot read rejection response: %s", err.Error())
+	}
+	if line != rejectedMessage+"\n" {
+		t.Fatalf("expected rejection %q, got %q", rejectedMessage, line)
+	}
+}
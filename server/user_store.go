@@ -0,0 +1,102 @@
+package server
+
+import (
+	"github.com/tg123/go-htpasswd"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// UserStore validates HTTP Basic Auth credentials against an htpasswd file (see `htpasswd(1)`), supporting
+// bcrypt, SHA1 and MD5 (apr1) entries. The file is re-read whenever its mtime changes, so users can be
+// added or removed without restarting the server. This is meant as an optional, lightweight alternative
+// to the single shared HMAC key for servers with multiple users.
+type UserStore struct {
+	path    string
+	mu      sync.RWMutex
+	file    *htpasswd.File
+	modTime int64
+}
+
+// NewUserStore creates a UserStore backed by the htpasswd file at path. It returns an error if the file
+// cannot be read or parsed.
+func NewUserStore(path string) (*UserStore, error) {
+	store := &UserStore{path: path}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Authenticate returns true if username/password matches an entry in the htpasswd file.
+func (s *UserStore) Authenticate(username, password string) bool {
+	s.reloadIfChanged()
+	s.mu.RLock()
+	file := s.file
+	s.mu.RUnlock()
+	return file != nil && file.Match(username, password)
+}
+
+func (s *UserStore) reloadIfChanged() {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		log.Printf("cannot stat auth file %s: %s", s.path, err.Error())
+		return
+	}
+	s.mu.RLock()
+	changed := info.ModTime().UnixNano() != s.modTime
+	s.mu.RUnlock()
+	if !changed {
+		return
+	}
+	if err := s.reload(); err != nil {
+		log.Printf("cannot reload auth file %s: %s", s.path, err.Error())
+	}
+}
+
+func (s *UserStore) reload() error {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	file, err := htpasswd.New(s.path, htpasswd.DefaultSystems, nil)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.file = file
+	s.modTime = info.ModTime().UnixNano()
+	return nil
+}
+
+// withBasicAuth wraps next so that it requires valid HTTP Basic Auth credentials, checked against store,
+// before being invoked. A missing or invalid Authorization header is rejected with a 401 response carrying
+// a WWW-Authenticate header, which the "join" command uses to detect that a username is required.
+func withBasicAuth(store *UserStore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || !store.Authenticate(username, password) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="pcopy"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// WithOptionalBasicAuth is the config-driven entry point for htpasswd-style auth: if authFile is non-empty,
+// it loads a UserStore from it and wraps next in withBasicAuth; if authFile is empty, next is returned
+// unchanged, so servers that don't use multi-user auth pay no cost. Callers wire this in next to their other
+// auth schemes (e.g. the shared HMAC key) when assembling the HTTP handler for a clipboard.
+func WithOptionalBasicAuth(authFile string, next http.HandlerFunc) (http.HandlerFunc, error) {
+	if authFile == "" {
+		return next, nil
+	}
+	store, err := NewUserStore(authFile)
+	if err != nil {
+		return nil, err
+	}
+	return withBasicAuth(store, next), nil
+}
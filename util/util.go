@@ -0,0 +1,79 @@
+// Package util holds small helpers shared across pcopy's commands, client and server that don't belong to
+// any one of them specifically.
+package util
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Peeked is the result of peeking at the leading bytes of a reader without being able to put them back.
+// Callers that still need the rest of the stream must re-assemble it themselves, e.g. with
+// io.MultiReader(bytes.NewReader(peeked.PeakedBytes), r).
+type Peeked struct {
+	PeakedBytes []byte
+}
+
+// Peak reads from r up to the first newline or maxBytes, whichever comes first, and returns what it read.
+// Unlike bufio.Reader.Peek, the returned bytes are consumed from r and cannot be read again from it.
+func Peak(r io.Reader, maxBytes int) (*Peeked, error) {
+	buf := make([]byte, 0, maxBytes)
+	one := make([]byte, 1)
+	for len(buf) < maxBytes {
+		n, err := r.Read(one)
+		if n > 0 {
+			buf = append(buf, one[0])
+			if one[0] == '\n' {
+				break
+			}
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+	}
+	return &Peeked{PeakedBytes: buf}, nil
+}
+
+// ReadPassword reads a single line from in, stripping the trailing newline. It exists as its own function,
+// rather than a bare bufio.Scanner call at each call site, so a future switch to no-echo terminal input only
+// needs to change this one place.
+func ReadPassword(in io.Reader) ([]byte, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, err
+		}
+	}
+	return []byte(strings.TrimSuffix(string(line), "\r")), nil
+}
+
+// CollapseHome replaces the current user's home directory prefix in path with "~", for friendlier output.
+func CollapseHome(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if path == home {
+		return "~"
+	}
+	if strings.HasPrefix(path, home+string(filepath.Separator)) {
+		return "~" + strings.TrimPrefix(path, home)
+	}
+	return path
+}